@@ -0,0 +1,548 @@
+/*
+ *
+ * Copyright 2016 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package grpclb defines a grpclb balancer.
+//
+// To install grpclb balancer, import this package as:
+//
+//	import _ "github.com/qiaohao9/grpc/balancer/grpclb"
+package grpclb
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/qiaohao9/grpc"
+	"github.com/qiaohao9/grpc/attributes"
+	"github.com/qiaohao9/grpc/balancer"
+	lbpb "github.com/qiaohao9/grpc/balancer/grpclb/grpc_lb_v1"
+	grpclbstate "github.com/qiaohao9/grpc/balancer/grpclb/state"
+	"github.com/qiaohao9/grpc/connectivity"
+	"github.com/qiaohao9/grpc/credentials/insecure"
+	"github.com/qiaohao9/grpc/grpclog"
+	"github.com/qiaohao9/grpc/resolver"
+	"github.com/qiaohao9/grpc/serviceconfig"
+)
+
+// Name is the name of the grpclb balancer.
+const Name = "grpclb"
+
+// lbTokenKey is the metadata key under which the per-backend LB token is
+// sent to the backend on each RPC, mirroring the C++/Java grpclb clients.
+const lbTokenKey = "lb-token"
+
+// lbTokenAttributeKey is the key under which the per-backend LB token handed
+// out by the remote balancer is stashed on the resolver.Address handed to
+// the child policy, so the grpclb picker can find it again after the child
+// policy has made its pick.
+type lbTokenAttributeKey struct{}
+
+var logger = grpclog.Component("grpclb")
+
+// defaultFallbackTimeout is the fallback timeout used by the grpclb builder
+// registered by this package's init(); it can be overridden per-balancer
+// through the "initialFallbackTimeout"/"fallbackTimeout" service config
+// fields, and is shortened directly by tests via
+// newLBBuilderWithFallbackTimeout.
+const defaultFallbackTimeout = 10 * time.Second
+
+func init() {
+	balancer.Register(newLBBuilder())
+}
+
+// newLBBuilder creates a new grpclb balancer builder.
+func newLBBuilder() balancer.Builder {
+	return newLBBuilderWithFallbackTimeout(defaultFallbackTimeout)
+}
+
+// newLBBuilderWithFallbackTimeout creates a new grpclb balancer builder with
+// the given default fallback timeout, used in place of defaultFallbackTimeout
+// when the service config doesn't override it. Exported to tests only
+// through this internal (lowercase) constructor.
+func newLBBuilderWithFallbackTimeout(fallbackTimeout time.Duration) balancer.Builder {
+	return &lbBuilder{fallbackTimeout: fallbackTimeout}
+}
+
+type lbBuilder struct {
+	fallbackTimeout time.Duration
+}
+
+func (b *lbBuilder) Name() string {
+	return Name
+}
+
+func (b *lbBuilder) Build(cc balancer.ClientConn, opt balancer.BuildOptions) balancer.Balancer {
+	lb := &lbBalancer{
+		cc:                     cc,
+		target:                 opt.Target.Endpoint,
+		opt:                    opt,
+		picker:                 &errPicker{err: balancer.ErrNoSubConnAvailable},
+		clientStats:            newRPCStats(),
+		backendAddrs:           nil,
+		subConnToken:           make(map[balancer.SubConn]string),
+		ringSubConns:           make(map[string]balancer.SubConn),
+		scStates:               make(map[balancer.SubConn]connectivity.State),
+		fallbackTimeout:        b.fallbackTimeout,
+		initialFallbackTimeout: b.fallbackTimeout,
+	}
+	lb.childCC = &lbCCWrapper{lb: lb}
+	registerStats(lb.target, lb.clientStats)
+	return lb
+}
+
+func (b *lbBuilder) ParseConfig(c json.RawMessage) (serviceconfig.LoadBalancingConfig, error) {
+	return parseGRPCLBServiceConfig(c)
+}
+
+func parseGRPCLBServiceConfig(js json.RawMessage) (*grpclbServiceConfig, error) {
+	var cfg grpclbServiceConfig
+	if err := json.Unmarshal(js, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// lbBalancer is the balancer.Balancer implementation for grpclb. It
+// dispatches to a remote balancer to obtain a server list, and delegates
+// picking among the non-dropped backends to a configurable child policy
+// (round_robin by default).
+type lbBalancer struct {
+	cc     balancer.ClientConn
+	target string
+	opt    balancer.BuildOptions
+
+	mu sync.Mutex
+
+	// fullServerList is the most recent server list received from the
+	// remote balancer, including drop entries, used by the picker to
+	// apply drops in the order the remote balancer specified them.
+	fullServerList []*lbpb.Server
+
+	// backendAddrs is the subset of fullServerList that are actual
+	// backends (not drop entries), converted to resolver.Address with the
+	// LB token attached as an Attribute so the child policy's SubConns
+	// can be mapped back to their token.
+	backendAddrs []resolver.Address
+
+	picker balancer.Picker
+
+	// childPolicyName is the name of the currently configured child
+	// policy; child is the running instance wired up via childCC. These
+	// are unused when the ring_hash picking policy is selected instead.
+	childPolicyName string
+	child           balancer.Balancer
+	childCC         *lbCCWrapper
+
+	// ring, ringSubConns, and scStates back the ring_hash picking policy,
+	// an alternative to childPolicy delegation where lbBalancer manages
+	// SubConns directly so it can consult their readiness while walking
+	// the ring.
+	usingRingHash bool
+	ring          hashRing
+	hashHeader    string
+	ringSubConns  map[string]balancer.SubConn
+	scStates      map[balancer.SubConn]connectivity.State
+
+	// subConnToken records the LB token associated with each SubConn the
+	// child policy has created, keyed off the first address it was
+	// created with. The grpclb picker consults this after the child
+	// picker has chosen a SubConn so it can attach the right token.
+	subConnToken map[balancer.SubConn]string
+
+	// resolverBackendAddrs holds the plain (non-grpclb) backend addresses
+	// most recently provided by the resolver, used while falling back.
+	resolverBackendAddrs []resolver.Address
+	lastCfg              *grpclbServiceConfig
+	cancelRemote         context.CancelFunc
+
+	// currentBalancerAddr is the remote balancer address the running stream
+	// (if any) was started with; haveBalancerAddr distinguishes "no stream
+	// yet" from a zero-value address. Used by UpdateClientConnState to avoid
+	// tearing down and redialing the stream on a config-only update.
+	currentBalancerAddr resolver.Address
+	haveBalancerAddr    bool
+
+	// fallbackTimeout is how long to wait, after the remote balancer
+	// connection is lost, before falling back to resolverBackendAddrs.
+	// initialFallbackTimeout is the timeout used the first time a remote
+	// balancer is configured, before any server list has ever been
+	// received. Both default to the builder's fallback timeout and can be
+	// overridden independently by the grpclbServiceConfig; disableFallback
+	// suppresses fallback altogether, both from these timeouts and from an
+	// explicit FallbackResponse.
+	fallbackTimeout        time.Duration
+	initialFallbackTimeout time.Duration
+	disableFallback        bool
+
+	// fallbackTimer is armed whenever the remote balancer connection is
+	// down (or has never come up) and fallback is not disabled; it's
+	// disarmed as soon as a fresh server list is received. connectedToRemote
+	// records whether a server list has ever been received from any remote
+	// balancer (it is not reset when the balancer address changes), which
+	// picks initialFallbackTimeout vs fallbackTimeout when (re)arming.
+	fallbackTimer     *time.Timer
+	connectedToRemote bool
+
+	clientStats *rpcStats
+}
+
+// fallback reverts to the resolver-provided backend addresses, bypassing
+// the remote balancer's server list. It's invoked, directly or via
+// fallbackTimer, when the connection to the remote balancer is unavailable,
+// and is a no-op when disableFallback is set.
+func (lb *lbBalancer) fallback() {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	if lb.disableFallback {
+		return
+	}
+	lb.disarmFallbackTimerLocked()
+	lb.fullServerList = nil
+	lb.refreshSubConns(lb.resolverBackendAddrs, lb.lastCfg)
+}
+
+// scheduleFallback arms the fallback timer so lb.fallback runs if the
+// remote balancer isn't reachable again before it fires; a no-op if
+// fallback is disabled.
+func (lb *lbBalancer) scheduleFallback() {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.scheduleFallbackLocked()
+}
+
+// scheduleFallbackLocked is scheduleFallback for a caller already holding
+// lb.mu.
+func (lb *lbBalancer) scheduleFallbackLocked() {
+	if lb.disableFallback {
+		return
+	}
+	timeout := lb.fallbackTimeout
+	if !lb.connectedToRemote {
+		timeout = lb.initialFallbackTimeout
+	}
+	lb.disarmFallbackTimerLocked()
+	lb.fallbackTimer = time.AfterFunc(timeout, lb.fallback)
+}
+
+// disarmFallbackTimerLocked stops any pending fallback timer, e.g. because
+// a fresh server list arrived before it fired. Caller must hold lb.mu.
+func (lb *lbBalancer) disarmFallbackTimerLocked() {
+	if lb.fallbackTimer != nil {
+		lb.fallbackTimer.Stop()
+		lb.fallbackTimer = nil
+	}
+}
+
+// refreshSubConns pushes the current backendAddrs to the child policy,
+// creating it (or swapping it out) first if the configured child policy
+// name has changed.
+//
+// Caller must hold lb.mu. refreshSubConns releases it around every call
+// into the child policy (Close/Build/UpdateClientConnState), since those
+// synchronously call back into lbCCWrapper, which acquires lb.mu itself;
+// lb.mu is held again before refreshSubConns returns.
+func (lb *lbBalancer) refreshSubConns(backendAddrs []resolver.Address, cfg *grpclbServiceConfig) {
+	lb.backendAddrs = backendAddrs
+
+	if useRingHash(cfg) {
+		if !lb.usingRingHash && lb.child != nil {
+			old := lb.child
+			lb.child = nil
+			lb.childPolicyName = ""
+			lb.mu.Unlock()
+			old.Close()
+			lb.mu.Lock()
+		}
+		lb.usingRingHash = true
+		lb.hashHeader = cfg.HashHeader
+		lb.updateRingHash(backendAddrs)
+		return
+	}
+
+	if lb.usingRingHash {
+		lb.teardownRingHash()
+		lb.usingRingHash = false
+	}
+
+	builder := childBuilder(cfg)
+	name := defaultChildPolicy
+	if builder != nil {
+		name = builder.Name()
+	}
+	if lb.child == nil || lb.childPolicyName != name {
+		old := lb.child
+		lb.childPolicyName = name
+		lb.mu.Unlock()
+		if old != nil {
+			old.Close()
+		}
+		child := builder.Build(lb.childCC, lb.opt)
+		lb.mu.Lock()
+		lb.child = child
+	}
+
+	addrs := make([]resolver.Address, len(backendAddrs))
+	copy(addrs, backendAddrs)
+	child := lb.child
+	lb.mu.Unlock()
+	child.UpdateClientConnState(balancer.ClientConnState{
+		ResolverState: resolver.State{Addresses: addrs},
+	})
+	lb.mu.Lock()
+}
+
+// regeneratePicker is called by the childCC wrapper whenever the child
+// policy updates its own picker; it wraps the child's picker with grpclb's
+// drop/token handling and forwards the combined picker up to cc.
+func (lb *lbBalancer) regeneratePicker(childPicker balancer.Picker, state connectivity.State) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+	lb.picker = newLBPicker(lb, lb.fullServerList, childPicker, lb.clientStats)
+	lb.cc.UpdateState(balancer.State{ConnectivityState: state, Picker: lb.picker})
+}
+
+func (lb *lbBalancer) UpdateClientConnState(ccs balancer.ClientConnState) error {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	cfg, _ := ccs.BalancerConfig.(*grpclbServiceConfig)
+	lb.lastCfg = cfg
+	if cfg != nil {
+		lb.disableFallback = cfg.DisableFallback
+		if cfg.InitialFallbackTimeout != nil {
+			lb.initialFallbackTimeout = time.Duration(*cfg.InitialFallbackTimeout)
+		}
+		if cfg.FallbackTimeout != nil {
+			lb.fallbackTimeout = time.Duration(*cfg.FallbackTimeout)
+		}
+	}
+
+	// Addresses directly in the resolver state (not of type GRPCLB) are
+	// treated as plain backends, used directly or as a fallback when the
+	// remote balancer stream is unavailable.
+	var backendAddrs []resolver.Address
+	for _, a := range ccs.ResolverState.Addresses {
+		if a.Type != resolver.GRPCLB {
+			backendAddrs = append(backendAddrs, a)
+		}
+	}
+	lb.resolverBackendAddrs = backendAddrs
+
+	// A remote balancer may be reachable either via a GRPCLB-typed address
+	// in the resolver state, or via the grpclbstate attribute a
+	// grpclb-aware resolver uses to keep it separate from the backends it
+	// also returns (see TestGRPCLB).
+	haveRemoteBalancer := false
+	for _, a := range ccs.ResolverState.Addresses {
+		if a.Type == resolver.GRPCLB {
+			haveRemoteBalancer = true
+		}
+	}
+	if gs, ok := grpclbstate.Get(ccs.ResolverState); ok && len(gs.BalancerAddresses) > 0 {
+		haveRemoteBalancer = true
+	}
+
+	if !haveRemoteBalancer {
+		// No remote balancer known: use the resolver-provided backends
+		// directly through the child policy.
+		lb.fullServerList = nil
+		lb.refreshSubConns(backendAddrs, cfg)
+		return nil
+	}
+
+	var balancerAddrs []resolver.Address
+	for _, a := range ccs.ResolverState.Addresses {
+		if a.Type == resolver.GRPCLB {
+			balancerAddrs = append(balancerAddrs, a)
+		}
+	}
+	if gs, ok := grpclbstate.Get(ccs.ResolverState); ok {
+		balancerAddrs = append(balancerAddrs, gs.BalancerAddresses...)
+	}
+	if !lb.haveBalancerAddr || lb.currentBalancerAddr != balancerAddrs[0] {
+		lb.scheduleFallbackLocked()
+		lb.currentBalancerAddr = balancerAddrs[0]
+		lb.haveBalancerAddr = true
+		lb.startBalancerStream(balancerAddrs[0], cfg)
+		return nil
+	}
+	// The remote balancer address is unchanged, so the running stream (and
+	// the server list it already delivered) is still valid; only cfg
+	// changed, e.g. a new childPolicy. Re-apply the cached server list so
+	// the child policy picks up the change without waiting for a fresh
+	// ServerList that may never come.
+	lb.refreshSubConns(lb.backendAddrs, cfg)
+	return nil
+}
+
+// startBalancerStream (re)establishes the connection to the remote
+// balancer, stopping any previously running stream first. Caller must hold
+// lb.mu.
+func (lb *lbBalancer) startBalancerStream(addr resolver.Address, cfg *grpclbServiceConfig) {
+	if lb.cancelRemote != nil {
+		lb.cancelRemote()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	lb.cancelRemote = cancel
+	go lb.runRemoteBalancer(ctx, addr, cfg)
+}
+
+// runRemoteBalancer dials the remote balancer and streams its server list
+// updates until ctx is cancelled, falling back to the resolver-provided
+// backends (if any) whenever the stream is unavailable.
+func (lb *lbBalancer) runRemoteBalancer(ctx context.Context, addr resolver.Address, cfg *grpclbServiceConfig) {
+	var dialOpts []grpc.DialOption
+	if lb.opt.DialCreds != nil {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(lb.opt.DialCreds))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if lb.opt.Dialer != nil {
+		dialOpts = append(dialOpts, grpc.WithContextDialer(lb.opt.Dialer))
+	}
+	if lb.opt.CustomUserAgent != "" {
+		dialOpts = append(dialOpts, grpc.WithUserAgent(lb.opt.CustomUserAgent))
+	}
+	cc, err := grpc.DialContext(ctx, addr.Addr, dialOpts...)
+	if err != nil {
+		logger.Warningf("grpclb: failed to dial remote balancer %v: %v", addr.Addr, err)
+		lb.scheduleFallback()
+		return
+	}
+	defer cc.Close()
+	if err := lb.callRemoteBalancer(ctx, cc, lb.target, addr.ServerName, cfg); err != nil && ctx.Err() == nil {
+		logger.Warningf("grpclb: remote balancer stream to %v failed: %v", addr.Addr, err)
+	}
+	if ctx.Err() == nil {
+		lb.scheduleFallback()
+	}
+}
+
+func (lb *lbBalancer) ResolverError(error) {
+	// Ignored: a resolver error does not change the set of backends we
+	// already know about; the next successful resolution will correct
+	// things, mirroring the child policy's own handling.
+}
+
+// UpdateSubConnState releases lb.mu before calling into the child policy
+// (see refreshSubConns), since it synchronously calls back into
+// lbCCWrapper, which acquires lb.mu itself.
+func (lb *lbBalancer) UpdateSubConnState(sc balancer.SubConn, s balancer.SubConnState) {
+	lb.mu.Lock()
+	if lb.usingRingHash {
+		if _, ok := lb.scStates[sc]; ok {
+			lb.scStates[sc] = s.ConnectivityState
+			lb.regenerateRingHashPicker()
+		}
+		lb.mu.Unlock()
+		return
+	}
+	child := lb.child
+	lb.mu.Unlock()
+	if child != nil {
+		child.UpdateSubConnState(sc, s)
+	}
+}
+
+// Close releases lb.mu before calling into the child policy's Close (see
+// refreshSubConns).
+func (lb *lbBalancer) Close() {
+	unregisterStats(lb.target)
+	lb.mu.Lock()
+	if lb.cancelRemote != nil {
+		lb.cancelRemote()
+	}
+	lb.disarmFallbackTimerLocked()
+	if lb.usingRingHash {
+		lb.teardownRingHash()
+	}
+	child := lb.child
+	lb.child = nil
+	lb.mu.Unlock()
+	if child != nil {
+		child.Close()
+	}
+}
+
+// lbCCWrapper intercepts the balancer.ClientConn calls made by the child
+// policy so grpclb can see the picker it produces (to layer drop/token
+// handling on top) without the child needing any grpclb-specific code.
+type lbCCWrapper struct {
+	lb *lbBalancer
+}
+
+func (w *lbCCWrapper) NewSubConn(addrs []resolver.Address, opts balancer.NewSubConnOptions) (balancer.SubConn, error) {
+	sc, err := w.lb.cc.NewSubConn(addrs, opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) > 0 {
+		if token, ok := lbTokenFromAttributes(addrs[0].Attributes); ok {
+			w.lb.mu.Lock()
+			w.lb.subConnToken[sc] = token
+			w.lb.mu.Unlock()
+		}
+	}
+	return sc, nil
+}
+
+func (w *lbCCWrapper) RemoveSubConn(sc balancer.SubConn) {
+	w.lb.mu.Lock()
+	delete(w.lb.subConnToken, sc)
+	w.lb.mu.Unlock()
+	w.lb.cc.RemoveSubConn(sc)
+}
+
+func (w *lbCCWrapper) UpdateAddresses(sc balancer.SubConn, addrs []resolver.Address) {
+	w.lb.cc.UpdateAddresses(sc, addrs)
+}
+
+func (w *lbCCWrapper) UpdateState(state balancer.State) {
+	w.lb.regeneratePicker(state.Picker, state.ConnectivityState)
+}
+
+func (w *lbCCWrapper) ResolveNow(o resolver.ResolveNowOptions) {
+	w.lb.cc.ResolveNow(o)
+}
+
+func (w *lbCCWrapper) Target() string {
+	return w.lb.target
+}
+
+// errPicker always returns err on Pick.
+type errPicker struct {
+	err error
+}
+
+func (p *errPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	return balancer.PickResult{}, p.err
+}
+
+// withLBToken returns a copy of addr with the given LB token attached via
+// Attributes, so it can be recovered from the SubConn's address later.
+func withLBToken(addr resolver.Address, token string) resolver.Address {
+	addr.Attributes = addr.Attributes.WithValue(lbTokenAttributeKey{}, token)
+	return addr
+}
+
+func lbTokenFromAttributes(attr *attributes.Attributes) (string, bool) {
+	v, ok := attr.Value(lbTokenAttributeKey{}).(string)
+	return v, ok
+}
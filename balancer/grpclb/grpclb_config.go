@@ -0,0 +1,141 @@
+/*
+ *
+ * Copyright 2018 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpclb
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/qiaohao9/grpc/balancer"
+	"github.com/qiaohao9/grpc/balancer/roundrobin"
+	"github.com/qiaohao9/grpc/serviceconfig"
+)
+
+// childPolicy is the balancer name and config for a grpclb child policy, as
+// it appears in a "childPolicy" service config list, e.g.
+// `{"round_robin":{}}`.
+type childPolicy struct {
+	Name   string
+	Config json.RawMessage
+}
+
+// childPolicyList is the custom JSON unmarshaler for the "childPolicy" list,
+// which is a list of single-key objects keyed by the child policy name.
+type childPolicyList []*childPolicy
+
+// UnmarshalJSON unmarshals a childPolicyList from the given JSON array, which
+// is a list of maps, each of which has a single entry with the policy name
+// as key and the policy's config as value.
+func (l *childPolicyList) UnmarshalJSON(b []byte) error {
+	var ms []map[string]json.RawMessage
+	if err := json.Unmarshal(b, &ms); err != nil {
+		return err
+	}
+	for i, m := range ms {
+		if len(m) != 1 {
+			return fmt.Errorf("childPolicy[%d] has more than 1 policy: %v", i, m)
+		}
+		for name, config := range m {
+			*l = append(*l, &childPolicy{Name: name, Config: config})
+		}
+	}
+	return nil
+}
+
+// duration unmarshals a JSON string like "1.5s" (as accepted by
+// time.ParseDuration) into a time.Duration, for the "*FallbackTimeout"
+// service config fields.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %v", s, err)
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+// ringHashPolicyName is the value of "pickingPolicy" that selects the
+// consistent-hash picking mode, as an alternative to delegating picking to
+// a childPolicy.
+const ringHashPolicyName = "ring_hash"
+
+// grpclbServiceConfig is the service config for the grpclb LB policy,
+// received through the "grpclb" key of a "loadBalancingConfig" entry, e.g.:
+//
+//	{"grpclb": {"childPolicy": [{"pick_first": {}}]}}
+//	{"grpclb": {"pickingPolicy": "ring_hash", "hashHeader": "x-user-id"}}
+type grpclbServiceConfig struct {
+	serviceconfig.LoadBalancingConfig
+	ChildPolicy *childPolicyList `json:"childPolicy,omitempty"`
+
+	// PickingPolicy, when set to "ring_hash", opts the balancer into
+	// consistent-hash picking among the non-dropped backends instead of
+	// the usual childPolicy delegation. HashHeader names the metadata key
+	// (or ":path" for the RPC path) whose value is hashed to pick a
+	// backend.
+	PickingPolicy string `json:"pickingPolicy,omitempty"`
+	HashHeader    string `json:"hashHeader,omitempty"`
+
+	// InitialFallbackTimeout overrides how long the balancer waits, before
+	// it has ever received a server list, before falling back to the
+	// resolver-provided backends. FallbackTimeout overrides how long it
+	// waits after losing the remote balancer connection before falling
+	// back again. Both default to the builder's configured fallback
+	// timeout (10s unless a test overrides it). DisableFallback, if true,
+	// disables fallback altogether, including in response to an explicit
+	// FallbackResponse from the remote balancer, keeping the client in the
+	// balancer-directed state even with no reachable remote balancer.
+	InitialFallbackTimeout *duration `json:"initialFallbackTimeout,omitempty"`
+	FallbackTimeout        *duration `json:"fallbackTimeout,omitempty"`
+	DisableFallback        bool      `json:"disableFallback,omitempty"`
+}
+
+// useRingHash reports whether cfg selects the ring_hash picking policy.
+func useRingHash(cfg *grpclbServiceConfig) bool {
+	return cfg != nil && cfg.PickingPolicy == ringHashPolicyName
+}
+
+// defaultChildPolicy is used when no childPolicy is configured, or none of
+// the configured policies are registered with this binary.
+const defaultChildPolicy = roundrobin.Name
+
+// childBuilder returns the balancer.Builder to use for backend picking. Any
+// policy registered with the balancer registry (via balancer.Register) is
+// usable as a grpclb childPolicy, not just pick_first and round_robin: the
+// first name in cfg.ChildPolicy that resolves to a registered builder wins,
+// falling back to round_robin if none of them are registered in this
+// binary.
+func childBuilder(cfg *grpclbServiceConfig) balancer.Builder {
+	if cfg != nil && cfg.ChildPolicy != nil {
+		for _, cp := range *cfg.ChildPolicy {
+			if b := balancer.Get(cp.Name); b != nil {
+				return b
+			}
+			logger.Warningf("grpclb: child policy %q is not registered, skipping", cp.Name)
+		}
+	}
+	return balancer.Get(defaultChildPolicy)
+}
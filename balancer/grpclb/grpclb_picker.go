@@ -0,0 +1,165 @@
+/*
+ *
+ * Copyright 2017 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpclb
+
+import (
+	"sync"
+	"sync/atomic"
+
+	lbpb "github.com/qiaohao9/grpc/balancer/grpclb/grpc_lb_v1"
+
+	"github.com/qiaohao9/grpc/balancer"
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/metadata"
+	"github.com/qiaohao9/grpc/status"
+)
+
+// rpcStats is used to aggregate the RPC stats that are periodically reported
+// to the remote balancer over the LoadBalancer stream.
+type rpcStats struct {
+	// Access to the following four fields should be done atomically.
+	numCallsStarted                        int64
+	numCallsFinished                       int64
+	numCallsFinishedWithClientFailedToSend int64
+	numCallsFinishedKnownReceived          int64
+
+	mu sync.Mutex
+	// map load_balance_token -> num calls dropped for that token.
+	numCallsDropped map[string]int64
+}
+
+func newRPCStats() *rpcStats {
+	return &rpcStats{
+		numCallsDropped: make(map[string]int64),
+	}
+}
+
+func (s *rpcStats) drop(token string) {
+	atomic.AddInt64(&s.numCallsStarted, 1)
+	s.mu.Lock()
+	s.numCallsDropped[token]++
+	s.mu.Unlock()
+	atomic.AddInt64(&s.numCallsFinished, 1)
+}
+
+func (s *rpcStats) callStarted() {
+	atomic.AddInt64(&s.numCallsStarted, 1)
+}
+
+func (s *rpcStats) callFailedToSend() {
+	atomic.AddInt64(&s.numCallsFinished, 1)
+	atomic.AddInt64(&s.numCallsFinishedWithClientFailedToSend, 1)
+}
+
+func (s *rpcStats) callFinished(received bool) {
+	atomic.AddInt64(&s.numCallsFinished, 1)
+	if received {
+		atomic.AddInt64(&s.numCallsFinishedKnownReceived, 1)
+	}
+}
+
+// toClientStats returns a snapshot of s as a ClientStats proto, for shipping
+// to the remote balancer, and resets the counters it read.
+func (s *rpcStats) toClientStats() *lbpb.ClientStats {
+	stats := &lbpb.ClientStats{
+		NumCallsStarted:                        atomic.SwapInt64(&s.numCallsStarted, 0),
+		NumCallsFinished:                       atomic.SwapInt64(&s.numCallsFinished, 0),
+		NumCallsFinishedWithClientFailedToSend: atomic.SwapInt64(&s.numCallsFinishedWithClientFailedToSend, 0),
+		NumCallsFinishedKnownReceived:          atomic.SwapInt64(&s.numCallsFinishedKnownReceived, 0),
+	}
+	s.mu.Lock()
+	for token, count := range s.numCallsDropped {
+		if count == 0 {
+			continue
+		}
+		stats.CallsFinishedWithDrop = append(stats.CallsFinishedWithDrop, &lbpb.ClientStatsPerToken{
+			LoadBalanceToken: token,
+			NumCalls:         count,
+		})
+		s.numCallsDropped[token] = 0
+	}
+	s.mu.Unlock()
+	return stats
+}
+
+// lbPicker layers grpclb's drop handling and per-backend LB-token metadata
+// injection on top of a child policy's picker. The drop decision is made by
+// cycling through the full server list (as returned by the remote balancer,
+// including drop entries) in order, so that drop rates are honored exactly
+// as specified; picking among the remaining, non-dropped backends is
+// delegated entirely to childPicker.
+type lbPicker struct {
+	lb          *lbBalancer
+	serverList  []*lbpb.Server
+	idx         uint32
+	childPicker balancer.Picker
+	stats       *rpcStats
+}
+
+func newLBPicker(lb *lbBalancer, serverList []*lbpb.Server, childPicker balancer.Picker, stats *rpcStats) *lbPicker {
+	return &lbPicker{
+		lb:          lb,
+		serverList:  serverList,
+		childPicker: childPicker,
+		stats:       stats,
+	}
+}
+
+func (p *lbPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if len(p.serverList) > 0 {
+		i := atomic.AddUint32(&p.idx, 1) - 1
+		entry := p.serverList[i%uint32(len(p.serverList))]
+		if entry.Drop {
+			p.stats.drop(entry.LoadBalanceToken)
+			return balancer.PickResult{}, status.Errorf(codes.Unavailable, "request dropped by grpclb")
+		}
+	}
+
+	if p.childPicker == nil {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+
+	result, err := p.childPicker.Pick(info)
+	if err != nil {
+		// A transient condition such as ErrNoSubConnAvailable never started
+		// an RPC, so it must not be counted as a finished call.
+		return result, err
+	}
+	p.stats.callStarted()
+
+	p.lb.mu.Lock()
+	token := p.lb.subConnToken[result.SubConn]
+	p.lb.mu.Unlock()
+	if token != "" {
+		result.Metadata = metadata.Join(result.Metadata, metadata.Pairs(lbTokenKey, token))
+	}
+
+	done := result.Done
+	result.Done = func(di balancer.DoneInfo) {
+		if !di.BytesSent {
+			p.stats.callFailedToSend()
+		} else {
+			p.stats.callFinished(di.BytesReceived)
+		}
+		if done != nil {
+			done(di)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,167 @@
+/*
+ *
+ * Copyright 2017 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpclb
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/qiaohao9/grpc"
+	lbgrpc "github.com/qiaohao9/grpc/balancer/grpclb/grpc_lb_v1"
+	lbpb "github.com/qiaohao9/grpc/balancer/grpclb/grpc_lb_v1"
+	"github.com/qiaohao9/grpc/resolver"
+)
+
+// defaultReportInterval is the load-report cadence used when the remote
+// balancer's InitialLoadBalanceResponse doesn't specify one.
+const defaultReportInterval = time.Minute
+
+// serverListAddr converts a single ServerList entry into a resolver.Address
+// carrying its LB token, for handoff to the child policy.
+func serverListAddr(s *lbpb.Server) resolver.Address {
+	ip := net.IP(s.IpAddress)
+	addr := resolver.Address{Addr: net.JoinHostPort(ip.String(), strconv.Itoa(int(s.Port)))}
+	return withLBToken(addr, s.LoadBalanceToken)
+}
+
+// processServerList is invoked every time the remote balancer stream
+// delivers a new ServerList. It records the full list (needed by the
+// picker to replay drops in the balancer-specified order) and hands the
+// non-dropped backends to the configured child policy, as set by the most
+// recent service config (lb.lastCfg) rather than the cfg the stream was
+// started with.
+func (lb *lbBalancer) processServerList(sl *lbpb.ServerList, cfg *grpclbServiceConfig) {
+	lb.mu.Lock()
+	defer lb.mu.Unlock()
+
+	lb.connectedToRemote = true
+	lb.disarmFallbackTimerLocked()
+	lb.fullServerList = sl.Servers
+	var backendAddrs []resolver.Address
+	for _, s := range sl.Servers {
+		if s.Drop {
+			continue
+		}
+		backendAddrs = append(backendAddrs, serverListAddr(s))
+	}
+	lb.refreshSubConns(backendAddrs, lb.lastCfg)
+}
+
+// callRemoteBalancer opens the LoadBalancer streaming RPC against addr and
+// feeds every ServerList it receives into processServerList, until ctx is
+// cancelled or the stream is closed by the remote balancer (the caller is
+// expected to fall back to the resolver-provided backends, if any, when
+// this returns). lbServerName is the remote balancer's ServerName, used
+// only to identify it to a registered StatsHandler.
+func (lb *lbBalancer) callRemoteBalancer(ctx context.Context, cc *grpc.ClientConn, serviceName, lbServerName string, cfg *grpclbServiceConfig) error {
+	lbClient := lbgrpc.NewLoadBalancerClient(cc)
+	stream, err := lbClient.BalanceLoad(ctx)
+	if err != nil {
+		return err
+	}
+	initReq := &lbpb.LoadBalanceRequest{
+		LoadBalanceRequestType: &lbpb.LoadBalanceRequest_InitialRequest{
+			InitialRequest: &lbpb.InitialLoadBalanceRequest{Name: serviceName},
+		},
+	}
+	if err := stream.Send(initReq); err != nil {
+		return err
+	}
+	reply, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	initResp := reply.GetInitialResponse()
+	if initResp == nil {
+		return err
+	}
+
+	reportInterval := defaultReportInterval
+	if ri := initResp.GetClientStatsReportInterval(); ri != nil && ri.AsDuration() > 0 {
+		reportInterval = ri.AsDuration()
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go lb.sendLoadReport(stream, reportInterval, lbServerName, done)
+
+	for {
+		reply, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		if sl := reply.GetServerList(); sl != nil {
+			lb.processServerList(sl, cfg)
+			continue
+		}
+		if reply.GetFallbackResponse() != nil {
+			lb.fallback()
+			continue
+		}
+	}
+}
+
+// sendLoadReport periodically ships a ClientStats snapshot to the remote
+// balancer over stream, and, if RegisterStatsHandler installed one, pushes
+// the identical snapshot to it, until done is closed. Reports with nothing
+// to say are quashed, except for the very first one, so an idle balancer
+// doesn't spam empty reports every interval.
+func (lb *lbBalancer) sendLoadReport(stream lbgrpc.LoadBalancer_BalanceLoadClient, interval time.Duration, lbServerName string, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	first := true
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+		stats := lb.clientStats.toClientStats()
+		if !first && isZeroClientStats(stats) {
+			continue
+		}
+		first = false
+		req := &lbpb.LoadBalanceRequest{
+			LoadBalanceRequestType: &lbpb.LoadBalanceRequest_ClientStats{
+				ClientStats: stats,
+			},
+		}
+		if err := stream.Send(req); err != nil {
+			return
+		}
+		if h := currentStatsHandler(); h != nil {
+			h(&ClientStats{Target: lb.target, LBServerName: lbServerName, Stats: statsFromProto(stats)})
+		}
+	}
+}
+
+// isZeroClientStats reports whether s has nothing to report.
+func isZeroClientStats(s *lbpb.ClientStats) bool {
+	if s.NumCallsStarted != 0 || s.NumCallsFinished != 0 ||
+		s.NumCallsFinishedWithClientFailedToSend != 0 || s.NumCallsFinishedKnownReceived != 0 {
+		return false
+	}
+	for _, d := range s.CallsFinishedWithDrop {
+		if d.NumCalls != 0 {
+			return false
+		}
+	}
+	return true
+}
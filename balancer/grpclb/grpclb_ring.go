@@ -0,0 +1,237 @@
+/*
+ *
+ * Copyright 2022 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpclb
+
+import (
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/qiaohao9/grpc/balancer"
+	lbpb "github.com/qiaohao9/grpc/balancer/grpclb/grpc_lb_v1"
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/connectivity"
+	"github.com/qiaohao9/grpc/internal/xxhash"
+	"github.com/qiaohao9/grpc/metadata"
+	"github.com/qiaohao9/grpc/resolver"
+	"github.com/qiaohao9/grpc/status"
+)
+
+// ringHashVirtualNodes is M, the number of virtual nodes (points) each
+// backend contributes to the ring.
+const ringHashVirtualNodes = 100
+
+// ringEntry is one virtual node on the consistent-hash ring.
+type ringEntry struct {
+	hash uint64
+	addr string
+}
+
+// hashRing is a sorted-by-hash set of ringEntry, built from the current
+// non-dropped backend addresses, with each backend contributing
+// ringHashVirtualNodes points placed by xxhash(addr + "#" + replica).
+type hashRing []ringEntry
+
+func newHashRing(addrs []resolver.Address) hashRing {
+	ring := make(hashRing, 0, len(addrs)*ringHashVirtualNodes)
+	for _, a := range addrs {
+		for replica := 0; replica < ringHashVirtualNodes; replica++ {
+			h := xxhash.Sum64String(a.Addr + "#" + strconv.Itoa(replica))
+			ring = append(ring, ringEntry{hash: h, addr: a.Addr})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool { return ring[i].hash < ring[j].hash })
+	return ring
+}
+
+// lookup returns the index of the first ring entry whose hash is >= h,
+// wrapping around to 0 if h is larger than every point on the ring.
+func (r hashRing) lookup(h uint64) int {
+	i := sort.Search(len(r), func(i int) bool { return r[i].hash >= h })
+	if i == len(r) {
+		i = 0
+	}
+	return i
+}
+
+// updateRingHash rebuilds the ring for the ring_hash picking policy and
+// makes sure a SubConn exists for every current backend address, tearing
+// down SubConns for addresses that are no longer present.
+//
+// Caller must hold lb.mu.
+func (lb *lbBalancer) updateRingHash(backendAddrs []resolver.Address) {
+	wantAddrs := make(map[string]resolver.Address, len(backendAddrs))
+	for _, a := range backendAddrs {
+		wantAddrs[a.Addr] = a
+	}
+	for addr, sc := range lb.ringSubConns {
+		if _, ok := wantAddrs[addr]; !ok {
+			lb.cc.RemoveSubConn(sc)
+			delete(lb.ringSubConns, addr)
+			delete(lb.scStates, sc)
+		}
+	}
+	for addr, a := range wantAddrs {
+		if _, ok := lb.ringSubConns[addr]; ok {
+			continue
+		}
+		sc, err := lb.cc.NewSubConn([]resolver.Address{a}, balancer.NewSubConnOptions{})
+		if err != nil {
+			logger.Warningf("grpclb: ring_hash failed to create SubConn for %v: %v", addr, err)
+			continue
+		}
+		lb.ringSubConns[addr] = sc
+		lb.scStates[sc] = connectivity.Idle
+		if token, ok := lbTokenFromAttributes(a.Attributes); ok {
+			lb.subConnToken[sc] = token
+		}
+		sc.Connect()
+	}
+
+	lb.ring = newHashRing(backendAddrs)
+	lb.regenerateRingHashPicker()
+}
+
+// regenerateRingHashPicker pushes a fresh ringHashPicker (reflecting the
+// current ring and SubConn states) up to cc.
+//
+// Caller must hold lb.mu.
+func (lb *lbBalancer) regenerateRingHashPicker() {
+	lb.picker = newRingHashPicker(lb, lb.fullServerList, lb.ring, lb.hashHeader, lb.clientStats)
+	lb.cc.UpdateState(balancer.State{
+		ConnectivityState: aggregateRingHashState(lb.scStates),
+		Picker:            lb.picker,
+	})
+}
+
+// teardownRingHash removes every SubConn created for the ring_hash picking
+// policy, used when switching back to childPolicy delegation.
+//
+// Caller must hold lb.mu.
+func (lb *lbBalancer) teardownRingHash() {
+	for addr, sc := range lb.ringSubConns {
+		lb.cc.RemoveSubConn(sc)
+		delete(lb.ringSubConns, addr)
+		delete(lb.scStates, sc)
+	}
+	lb.ring = nil
+}
+
+// aggregateRingHashState reports connectivity.Ready if any SubConn is
+// READY, mirroring the aggregation rule used by round_robin.
+func aggregateRingHashState(states map[balancer.SubConn]connectivity.State) connectivity.State {
+	sawConnecting := false
+	for _, st := range states {
+		if st == connectivity.Ready {
+			return connectivity.Ready
+		}
+		if st == connectivity.Connecting {
+			sawConnecting = true
+		}
+	}
+	if sawConnecting {
+		return connectivity.Connecting
+	}
+	return connectivity.TransientFailure
+}
+
+// ringHashPicker implements consistent-hash picking among the non-dropped
+// grpclb backends, as an alternative to delegating to a child policy.
+type ringHashPicker struct {
+	lb         *lbBalancer
+	serverList []*lbpb.Server
+	ring       hashRing
+	hashHeader string
+	idx        uint32
+	stats      *rpcStats
+}
+
+func newRingHashPicker(lb *lbBalancer, serverList []*lbpb.Server, ring hashRing, hashHeader string, stats *rpcStats) *ringHashPicker {
+	return &ringHashPicker{lb: lb, serverList: serverList, ring: ring, hashHeader: hashHeader, stats: stats}
+}
+
+// hashKey returns the string to hash for info: the configured header's
+// value if present, otherwise the RPC's ":path".
+func (p *ringHashPicker) hashKey(info balancer.PickInfo) string {
+	if p.hashHeader != "" {
+		if md, ok := metadata.FromOutgoingContext(info.Ctx); ok {
+			if vs := md.Get(p.hashHeader); len(vs) > 0 {
+				return vs[0]
+			}
+		}
+	}
+	return info.FullMethod
+}
+
+// pickReady walks the ring starting at the entry that owns h, returning the
+// first backend whose SubConn is READY.
+func (p *ringHashPicker) pickReady(h uint64) (balancer.SubConn, bool) {
+	p.lb.mu.Lock()
+	defer p.lb.mu.Unlock()
+	n := len(p.ring)
+	if n == 0 {
+		return nil, false
+	}
+	start := p.ring.lookup(h)
+	for i := 0; i < n; i++ {
+		e := p.ring[(start+i)%n]
+		sc, ok := p.lb.ringSubConns[e.addr]
+		if !ok {
+			continue
+		}
+		if p.lb.scStates[sc] == connectivity.Ready {
+			return sc, true
+		}
+	}
+	return nil, false
+}
+
+func (p *ringHashPicker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	if len(p.serverList) > 0 {
+		i := atomic.AddUint32(&p.idx, 1) - 1
+		entry := p.serverList[i%uint32(len(p.serverList))]
+		if entry.Drop {
+			p.stats.drop(entry.LoadBalanceToken)
+			return balancer.PickResult{}, status.Errorf(codes.Unavailable, "request dropped by grpclb")
+		}
+	}
+
+	h := xxhash.Sum64String(p.hashKey(info))
+	sc, ok := p.pickReady(h)
+	if !ok {
+		return balancer.PickResult{}, balancer.ErrNoSubConnAvailable
+	}
+
+	p.stats.callStarted()
+	p.lb.mu.Lock()
+	token := p.lb.subConnToken[sc]
+	p.lb.mu.Unlock()
+	result := balancer.PickResult{SubConn: sc}
+	if token != "" {
+		result.Metadata = metadata.Pairs(lbTokenKey, token)
+	}
+	result.Done = func(di balancer.DoneInfo) {
+		if !di.BytesSent {
+			p.stats.callFailedToSend()
+		} else {
+			p.stats.callFinished(di.BytesReceived)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,159 @@
+/*
+ *
+ * Copyright 2021 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package grpclb
+
+import (
+	"sync"
+	"sync/atomic"
+
+	lbpb "github.com/qiaohao9/grpc/balancer/grpclb/grpc_lb_v1"
+)
+
+// Stats is a point-in-time snapshot of the client-side RPC counters that
+// grpclb also periodically ships to the remote balancer over the
+// LoadBalancer stream. It lets a user observe drop rates, per-token drop
+// reasons, and in-flight/finished call counts without having to run a fake
+// balancer to intercept the load report. Every counter covers only the calls
+// since the previous load report: sending a report to the remote balancer
+// resets them to zero.
+type Stats struct {
+	// NumCallsStarted is the number of calls started.
+	NumCallsStarted int64
+	// NumCallsFinished is the number of calls finished, successfully or
+	// not.
+	NumCallsFinished int64
+	// NumCallsFinishedWithClientFailedToSend is the number of calls that
+	// failed to be sent to a backend.
+	NumCallsFinishedWithClientFailedToSend int64
+	// NumCallsFinishedKnownReceived is the number of calls that finished
+	// and were known to have been received by a backend.
+	NumCallsFinishedKnownReceived int64
+	// NumCallsDropped maps the LB token of each grpclb drop entry to the
+	// number of calls dropped for it.
+	NumCallsDropped map[string]int64
+}
+
+// snapshot returns the current value of every counter in s, without
+// resetting them (unlike toClientStats, which is used for the load report
+// sent to the remote balancer).
+func (s *rpcStats) snapshot() Stats {
+	dropped := make(map[string]int64)
+	s.mu.Lock()
+	for token, count := range s.numCallsDropped {
+		dropped[token] = count
+	}
+	s.mu.Unlock()
+	return Stats{
+		NumCallsStarted:                        atomic.LoadInt64(&s.numCallsStarted),
+		NumCallsFinished:                       atomic.LoadInt64(&s.numCallsFinished),
+		NumCallsFinishedWithClientFailedToSend: atomic.LoadInt64(&s.numCallsFinishedWithClientFailedToSend),
+		NumCallsFinishedKnownReceived:          atomic.LoadInt64(&s.numCallsFinishedKnownReceived),
+		NumCallsDropped:                        dropped,
+	}
+}
+
+// statsRegistry tracks the rpcStats of every grpclb balancer instance
+// currently active, keyed by the dial target it was built for, so that
+// GRPCLBStats can be used without plumbing a handle out through the
+// balancer API.
+var statsRegistry sync.Map // target string -> *rpcStats
+
+func registerStats(target string, s *rpcStats) {
+	statsRegistry.Store(target, s)
+}
+
+func unregisterStats(target string) {
+	statsRegistry.Delete(target)
+}
+
+// GRPCLBStats returns a snapshot of the client-side load-report counters
+// that the grpclb balancer for the ClientConn dialing target is tracking,
+// and reports whether a grpclb balancer for that target was found. This
+// makes it practical to alert on drop-rate spikes without running a fake
+// balancer to capture them.
+func GRPCLBStats(target string) (Stats, bool) {
+	v, ok := statsRegistry.Load(target)
+	if !ok {
+		return Stats{}, false
+	}
+	return v.(*rpcStats).snapshot(), true
+}
+
+// ClientStats is a load-report snapshot pushed to a StatsHandler registered
+// with RegisterStatsHandler, identical in content to what was just reported
+// to the remote balancer over the LoadBalancer stream, along with enough
+// context to tell multiple grpclb balancers apart.
+type ClientStats struct {
+	// Target is the dial target (authority) of the ClientConn the
+	// reporting grpclb balancer belongs to.
+	Target string
+	// LBServerName is the ServerName of the remote balancer the report was
+	// sent to.
+	LBServerName string
+
+	Stats
+}
+
+// statsFromProto converts the ClientStats proto built for the wire load
+// report into a Stats value, so StatsHandler sees exactly the counters
+// that were reported to the remote balancer.
+func statsFromProto(s *lbpb.ClientStats) Stats {
+	var dropped map[string]int64
+	if len(s.CallsFinishedWithDrop) > 0 {
+		dropped = make(map[string]int64, len(s.CallsFinishedWithDrop))
+		for _, d := range s.CallsFinishedWithDrop {
+			dropped[d.LoadBalanceToken] = d.NumCalls
+		}
+	}
+	return Stats{
+		NumCallsStarted:                        s.NumCallsStarted,
+		NumCallsFinished:                       s.NumCallsFinished,
+		NumCallsFinishedWithClientFailedToSend: s.NumCallsFinishedWithClientFailedToSend,
+		NumCallsFinishedKnownReceived:          s.NumCallsFinishedKnownReceived,
+		NumCallsDropped:                        dropped,
+	}
+}
+
+// StatsHandler is the callback registered with RegisterStatsHandler.
+type StatsHandler func(*ClientStats)
+
+var (
+	statsHandlerMu sync.Mutex
+	statsHandler   StatsHandler
+)
+
+// RegisterStatsHandler installs h to be called with a ClientStats snapshot
+// every time a grpclb balancer reports load to its remote balancer, at the
+// same cadence as that load report. This lets callers export drop counts
+// and per-token drop reasons to a metrics system without running a fake
+// balancer to intercept the report. Only one handler may be registered at
+// a time; a later call replaces the previous handler. It is expected to be
+// called once, e.g. from an init function, before any grpclb ClientConn is
+// dialed.
+func RegisterStatsHandler(h StatsHandler) {
+	statsHandlerMu.Lock()
+	defer statsHandlerMu.Unlock()
+	statsHandler = h
+}
+
+func currentStatsHandler() StatsHandler {
+	statsHandlerMu.Lock()
+	defer statsHandlerMu.Unlock()
+	return statsHandler
+}
@@ -1566,3 +1566,497 @@ func (s) TestGRPCLBStatsQuashEmpty(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+// TestGRPCLBStatsPublicAPI checks that GRPCLBStats, which exposes grpclb's
+// client-side load-report counters to users without requiring a fake
+// balancer, reports a dropped-token map matching the number of RPCs the
+// client saw dropped (no load report is sent during the test, so nothing
+// has reset the counters).
+func (s) TestGRPCLBStatsPublicAPI(t *testing.T) {
+	r := manual.NewBuilderWithScheme("whatever")
+
+	tss, cleanup, err := newLoadBalancer(1, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create new load balancer: %v", err)
+	}
+	defer cleanup()
+	tss.ls.sls <- &lbpb.ServerList{
+		Servers: []*lbpb.Server{{
+			IpAddress:        tss.beIPs[0],
+			Port:             int32(tss.bePorts[0]),
+			LoadBalanceToken: lbToken,
+			Drop:             false,
+		}, {
+			LoadBalanceToken: lbToken,
+			Drop:             true,
+		}},
+	}
+	creds := serverNameCheckCreds{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cc, err := grpc.DialContext(ctx, r.Scheme()+":///"+beServerName, grpc.WithResolvers(r),
+		grpc.WithTransportCredentials(&creds), grpc.WithContextDialer(fakeNameDialer))
+	if err != nil {
+		t.Fatalf("Failed to dial to the backend %v", err)
+	}
+	defer cc.Close()
+	testC := testpb.NewTestServiceClient(cc)
+
+	r.UpdateState(resolver.State{Addresses: []resolver.Address{{
+		Addr:       tss.lbAddr,
+		Type:       resolver.GRPCLB,
+		ServerName: lbServerName,
+	}}})
+
+	const wantDrops = 3
+	var drops int
+	for i := 0; i < 1000 && drops < wantDrops; i++ {
+		if _, err := testC.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true)); status.Code(err) == codes.Unavailable {
+			drops++
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if drops < wantDrops {
+		t.Fatalf("got %d drops in 1000 RPCs, want at least %d", drops, wantDrops)
+	}
+
+	got, ok := GRPCLBStats(beServerName)
+	if !ok {
+		t.Fatalf("GRPCLBStats(%q) found no registered grpclb balancer", beServerName)
+	}
+	if got.NumCallsDropped[lbToken] != int64(drops) {
+		t.Errorf("GRPCLBStats(%q).NumCallsDropped[%q] = %d, want %d", beServerName, lbToken, got.NumCallsDropped[lbToken], drops)
+	}
+}
+
+// TestGRPCLBRingHash checks that the ring_hash picking policy routes every
+// RPC carrying the same hashed header value to the same backend. The server
+// list has no drop entries, so drops are not exercised here.
+func (s) TestGRPCLBRingHash(t *testing.T) {
+	r := manual.NewBuilderWithScheme("whatever")
+
+	tss, cleanup, err := newLoadBalancer(3, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create new load balancer: %v", err)
+	}
+	defer cleanup()
+
+	var servers []*lbpb.Server
+	for i := range tss.beIPs {
+		servers = append(servers, &lbpb.Server{
+			IpAddress:        tss.beIPs[i],
+			Port:             int32(tss.bePorts[i]),
+			LoadBalanceToken: lbToken,
+		})
+	}
+	tss.ls.sls <- &lbpb.ServerList{Servers: servers}
+
+	creds := serverNameCheckCreds{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cc, err := grpc.DialContext(ctx, r.Scheme()+":///"+beServerName, grpc.WithResolvers(r),
+		grpc.WithTransportCredentials(&creds), grpc.WithContextDialer(fakeNameDialer))
+	if err != nil {
+		t.Fatalf("Failed to dial to the backend %v", err)
+	}
+	defer cc.Close()
+	testC := testpb.NewTestServiceClient(cc)
+
+	const pfc = `{"loadBalancingConfig":[{"grpclb":{"pickingPolicy":"ring_hash","hashHeader":"x-user-id"}}]}`
+	scpr := r.CC.ParseServiceConfig(pfc)
+	if scpr.Err != nil {
+		t.Fatalf("Error parsing config %q: %v", pfc, scpr.Err)
+	}
+	r.UpdateState(resolver.State{
+		Addresses: []resolver.Address{{
+			Addr:       tss.lbAddr,
+			Type:       resolver.GRPCLB,
+			ServerName: lbServerName,
+		}},
+		ServiceConfig: scpr,
+	})
+
+	rpcCtx := metadata.AppendToOutgoingContext(ctx, "x-user-id", "user-42")
+	var want *net.TCPAddr
+	for i := 0; i < 50; i++ {
+		var p peer.Peer
+		if _, err := testC.EmptyCall(rpcCtx, &testpb.Empty{}, grpc.WaitForReady(true), grpc.Peer(&p)); err != nil {
+			t.Fatalf("_.EmptyCall(_, _) = _, %v, want _, <nil>", err)
+		}
+		got := p.Addr.(*net.TCPAddr)
+		if want == nil {
+			want = got
+			continue
+		}
+		if got.Port != want.Port {
+			t.Errorf("RPC %d landed on port %v, want consistently %v", i, got.Port, want.Port)
+		}
+	}
+}
+
+// testLastPickBalancer is a minimal custom balancer policy, registered only
+// by this test, used to verify that grpclb's childPolicy accepts any name
+// registered with balancer.Get, not just pick_first and round_robin. It
+// always picks the last backend address it was given.
+const testLastPickName = "test_grpclb_last_pick"
+
+type testLastPickBalancer struct {
+	cc balancer.ClientConn
+	sc balancer.SubConn
+}
+
+func (b *testLastPickBalancer) UpdateClientConnState(s balancer.ClientConnState) error {
+	addrs := s.ResolverState.Addresses
+	if len(addrs) == 0 {
+		return nil
+	}
+	old := b.sc
+	sc, err := b.cc.NewSubConn(addrs[len(addrs)-1:], balancer.NewSubConnOptions{})
+	if err != nil {
+		return err
+	}
+	b.sc = sc
+	if old != nil {
+		b.cc.RemoveSubConn(old)
+	}
+	sc.Connect()
+	return nil
+}
+
+func (b *testLastPickBalancer) ResolverError(error) {}
+
+func (b *testLastPickBalancer) UpdateSubConnState(sc balancer.SubConn, s balancer.SubConnState) {
+	switch s.ConnectivityState {
+	case connectivity.Ready:
+		b.cc.UpdateState(balancer.State{ConnectivityState: connectivity.Ready, Picker: &testLastPickPicker{sc: sc}})
+	case connectivity.TransientFailure:
+		b.cc.UpdateState(balancer.State{ConnectivityState: connectivity.TransientFailure, Picker: &errPicker{err: s.ConnectionError}})
+	}
+}
+
+func (b *testLastPickBalancer) Close() {}
+
+type testLastPickBuilder struct{}
+
+func (testLastPickBuilder) Name() string { return testLastPickName }
+func (testLastPickBuilder) Build(cc balancer.ClientConn, opt balancer.BuildOptions) balancer.Balancer {
+	return &testLastPickBalancer{cc: cc}
+}
+
+type testLastPickPicker struct {
+	sc balancer.SubConn
+}
+
+func (p *testLastPickPicker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	return balancer.PickResult{SubConn: p.sc}, nil
+}
+
+func init() {
+	balancer.Register(testLastPickBuilder{})
+}
+
+// TestGRPCLBCustomChildPolicy verifies that grpclb dispatches picks through
+// any childPolicy registered with the balancer registry, and that it
+// switches children cleanly when the service config changes.
+func (s) TestGRPCLBCustomChildPolicy(t *testing.T) {
+	r := manual.NewBuilderWithScheme("whatever")
+
+	tss, cleanup, err := newLoadBalancer(3, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create new load balancer: %v", err)
+	}
+	defer cleanup()
+
+	var beServers []*lbpb.Server
+	for i := range tss.beIPs {
+		beServers = append(beServers, &lbpb.Server{
+			IpAddress:        tss.beIPs[i],
+			Port:             int32(tss.bePorts[i]),
+			LoadBalanceToken: lbToken,
+		})
+	}
+	tss.ls.sls <- &lbpb.ServerList{Servers: beServers}
+
+	creds := serverNameCheckCreds{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cc, err := grpc.DialContext(ctx, r.Scheme()+":///"+beServerName, grpc.WithResolvers(r),
+		grpc.WithTransportCredentials(&creds), grpc.WithContextDialer(fakeNameDialer))
+	if err != nil {
+		t.Fatalf("Failed to dial to the backend %v", err)
+	}
+	defer cc.Close()
+	testC := testpb.NewTestServiceClient(cc)
+
+	const customChildConfig = `{"loadBalancingConfig":[{"grpclb":{"childPolicy":[{"test_grpclb_last_pick":{}}]}}]}`
+	scpr := r.CC.ParseServiceConfig(customChildConfig)
+	if scpr.Err != nil {
+		t.Fatalf("Error parsing config %q: %v", customChildConfig, scpr.Err)
+	}
+	r.UpdateState(resolver.State{
+		Addresses: []resolver.Address{{
+			Addr:       tss.lbAddr,
+			Type:       resolver.GRPCLB,
+			ServerName: lbServerName,
+		}},
+		ServiceConfig: scpr,
+	})
+
+	wantPort := tss.bePorts[len(tss.bePorts)-1]
+	for i := 0; i < 100; i++ {
+		var p peer.Peer
+		if _, err := testC.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true), grpc.Peer(&p)); err != nil {
+			t.Fatalf("_.EmptyCall(_, _) = _, %v, want _, <nil>", err)
+		}
+		if got := p.Addr.(*net.TCPAddr).Port; got != wantPort {
+			t.Fatalf("RPC %d landed on port %v, want %v (last backend via custom childPolicy)", i, got, wantPort)
+		}
+	}
+
+	// Switch to round_robin, and confirm grpclb now dispatches through it
+	// instead of the custom child.
+	const rrConfig = `{"loadBalancingConfig":[{"grpclb":{"childPolicy":[{"round_robin":{}}]}}]}`
+	scpr = r.CC.ParseServiceConfig(rrConfig)
+	if scpr.Err != nil {
+		t.Fatalf("Error parsing config %q: %v", rrConfig, scpr.Err)
+	}
+	r.UpdateState(resolver.State{
+		Addresses: []resolver.Address{{
+			Addr:       tss.lbAddr,
+			Type:       resolver.GRPCLB,
+			ServerName: lbServerName,
+		}},
+		ServiceConfig: scpr,
+	})
+
+	portsToIndex := make(map[int]int)
+	for i, p := range tss.bePorts {
+		portsToIndex[p] = i
+	}
+	var result string
+	for i := 0; i < 1000; i++ {
+		var p peer.Peer
+		if _, err := testC.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true), grpc.Peer(&p)); err != nil {
+			t.Fatalf("_.EmptyCall(_, _) = _, %v, want _, <nil>", err)
+		}
+		result += strconv.Itoa(portsToIndex[p.Addr.(*net.TCPAddr).Port])
+	}
+	if seq := "012"; !strings.Contains(result, strings.Repeat(seq, 100)) {
+		t.Errorf("got result sequence %q, want pattern %q repeated", result, seq)
+	}
+}
+
+// TestGRPCLBFallbackTimeoutServiceConfig verifies that an
+// "initialFallbackTimeout" in the grpclb service config is honored even
+// though the default grpclb builder (registered in init()) uses the much
+// longer defaultFallbackTimeout: no server list is ever sent by the remote
+// balancer, so the standalone backend is only reachable once the
+// configured 100ms timeout fires.
+func (s) TestGRPCLBFallbackTimeoutServiceConfig(t *testing.T) {
+	r := manual.NewBuilderWithScheme("whatever")
+
+	tss, cleanup, err := newLoadBalancer(1, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create new load balancer: %v", err)
+	}
+	defer cleanup()
+
+	beLis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen %v", err)
+	}
+	defer beLis.Close()
+	standaloneBEs := startBackends(beServerName, true, beLis)
+	defer stopBackends(standaloneBEs)
+
+	creds := serverNameCheckCreds{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cc, err := grpc.DialContext(ctx, r.Scheme()+":///"+beServerName, grpc.WithResolvers(r),
+		grpc.WithTransportCredentials(&creds), grpc.WithContextDialer(fakeNameDialer))
+	if err != nil {
+		t.Fatalf("Failed to dial to the backend %v", err)
+	}
+	defer cc.Close()
+	testC := testpb.NewTestServiceClient(cc)
+
+	const cfgJSON = `{"loadBalancingConfig":[{"grpclb":{"initialFallbackTimeout":"100ms"}}]}`
+	scpr := r.CC.ParseServiceConfig(cfgJSON)
+	if scpr.Err != nil {
+		t.Fatalf("Error parsing config %q: %v", cfgJSON, scpr.Err)
+	}
+
+	r.UpdateState(resolver.State{
+		Addresses: []resolver.Address{{
+			Addr:       tss.lbAddr,
+			Type:       resolver.GRPCLB,
+			ServerName: lbServerName,
+		}, {
+			Addr: beLis.Addr().String(),
+			Type: resolver.Backend,
+		}},
+		ServiceConfig: scpr,
+	})
+
+	var p peer.Peer
+	rpcCtx, rpcCancel := context.WithTimeout(context.Background(), time.Second)
+	defer rpcCancel()
+	if _, err := testC.EmptyCall(rpcCtx, &testpb.Empty{}, grpc.WaitForReady(true), grpc.Peer(&p)); err != nil {
+		t.Fatalf("_.EmptyCall(_, _) = _, %v, want _, <nil>", err)
+	}
+	if p.Addr.String() != beLis.Addr().String() {
+		t.Fatalf("got peer: %v, want peer: %v (fallback backend, via initialFallbackTimeout)", p.Addr, beLis.Addr())
+	}
+}
+
+// TestGRPCLBDisableFallback verifies that disableFallback=true keeps
+// grpclb dispatching to the balancer-directed backend even after the
+// remote balancer sends an explicit FallbackResponse.
+func (s) TestGRPCLBDisableFallback(t *testing.T) {
+	r := manual.NewBuilderWithScheme("whatever")
+
+	tss, cleanup, err := newLoadBalancer(1, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create new load balancer: %v", err)
+	}
+	defer cleanup()
+
+	beLis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Failed to listen %v", err)
+	}
+	defer beLis.Close()
+	standaloneBEs := startBackends(beServerName, true, beLis)
+	defer stopBackends(standaloneBEs)
+
+	be := &lbpb.Server{
+		IpAddress:        tss.beIPs[0],
+		Port:             int32(tss.bePorts[0]),
+		LoadBalanceToken: lbToken,
+	}
+	tss.ls.sls <- &lbpb.ServerList{Servers: []*lbpb.Server{be}}
+
+	creds := serverNameCheckCreds{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cc, err := grpc.DialContext(ctx, r.Scheme()+":///"+beServerName, grpc.WithResolvers(r),
+		grpc.WithTransportCredentials(&creds), grpc.WithContextDialer(fakeNameDialer))
+	if err != nil {
+		t.Fatalf("Failed to dial to the backend %v", err)
+	}
+	defer cc.Close()
+	testC := testpb.NewTestServiceClient(cc)
+
+	const cfgJSON = `{"loadBalancingConfig":[{"grpclb":{"disableFallback":true}}]}`
+	scpr := r.CC.ParseServiceConfig(cfgJSON)
+	if scpr.Err != nil {
+		t.Fatalf("Error parsing config %q: %v", cfgJSON, scpr.Err)
+	}
+	r.UpdateState(resolver.State{
+		Addresses: []resolver.Address{{
+			Addr:       tss.lbAddr,
+			Type:       resolver.GRPCLB,
+			ServerName: lbServerName,
+		}, {
+			Addr: beLis.Addr().String(),
+			Type: resolver.Backend,
+		}},
+		ServiceConfig: scpr,
+	})
+
+	var p peer.Peer
+	var backendUsed bool
+	for i := 0; i < 2000; i++ {
+		if _, err := testC.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true), grpc.Peer(&p)); err != nil {
+			t.Fatalf("%v.EmptyCall(_, _) = _, %v, want _, <nil>", testC, err)
+		}
+		if p.Addr.(*net.TCPAddr).Port == tss.bePorts[0] {
+			backendUsed = true
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if !backendUsed {
+		t.Fatalf("No RPC sent to backend behind remote balancer after 2 seconds")
+	}
+
+	// An explicit FallbackResponse would normally switch to the standalone
+	// fallback backend (see TestExplicitFallback); with disableFallback set,
+	// it must not.
+	tss.ls.fallbackNow()
+
+	for i := 0; i < 200; i++ {
+		if _, err := testC.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true), grpc.Peer(&p)); err != nil {
+			t.Fatalf("%v.EmptyCall(_, _) = _, %v, want _, <nil>", testC, err)
+		}
+		if p.Addr.String() == beLis.Addr().String() {
+			t.Fatalf("RPC %d landed on the fallback backend despite disableFallback=true", i)
+		}
+		if p.Addr.(*net.TCPAddr).Port != tss.bePorts[0] {
+			t.Fatalf("RPC %d landed on unexpected address %v", i, p.Addr)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestGRPCLBStatsPushHandler verifies that RegisterStatsHandler is invoked,
+// at the same cadence as the load report to the remote balancer, with
+// ClientStats snapshots whose counters add up to the existing rpcStats
+// expectations from TestGRPCLBStatsUnaryDrop. Each snapshot holds only the
+// calls since the previous report, so the test sums them.
+func (s) TestGRPCLBStatsPushHandler(t *testing.T) {
+	reports := make(chan *ClientStats, 10)
+	RegisterStatsHandler(func(cs *ClientStats) { reports <- cs })
+	defer RegisterStatsHandler(nil)
+
+	if err := runAndCheckStats(t, true, nil, func(cc *grpc.ClientConn) {
+		testC := testpb.NewTestServiceClient(cc)
+		ctx, cancel := context.WithTimeout(context.Background(), defaultFallbackTimeout)
+		defer cancel()
+		if _, err := testC.EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true)); err != nil {
+			t.Fatalf("%v.EmptyCall(_, _) = _, %v, want _, <nil>", testC, err)
+		}
+		for i := 0; i < countRPC-1; i++ {
+			testC.EmptyCall(ctx, &testpb.Empty{})
+		}
+
+		got := ClientStats{Stats: Stats{NumCallsDropped: make(map[string]int64)}}
+		timeout := time.After(5 * time.Second)
+		for got.NumCallsStarted != int64(countRPC) {
+			select {
+			case cs := <-reports:
+				got.Target, got.LBServerName = cs.Target, cs.LBServerName
+				got.NumCallsStarted += cs.NumCallsStarted
+				got.NumCallsFinished += cs.NumCallsFinished
+				got.NumCallsFinishedKnownReceived += cs.NumCallsFinishedKnownReceived
+				for token, n := range cs.NumCallsDropped {
+					got.NumCallsDropped[token] += n
+				}
+			case <-timeout:
+				t.Fatalf("did not observe a complete ClientStats push report in time")
+			}
+		}
+		if got.Target != beServerName {
+			t.Errorf("ClientStats.Target = %q, want %q", got.Target, beServerName)
+		}
+		if got.LBServerName != lbServerName {
+			t.Errorf("ClientStats.LBServerName = %q, want %q", got.LBServerName, lbServerName)
+		}
+		if got.NumCallsFinished != int64(countRPC) {
+			t.Errorf("ClientStats.NumCallsFinished = %d, want %d", got.NumCallsFinished, countRPC)
+		}
+		if got.NumCallsFinishedKnownReceived != int64(countRPC)/2 {
+			t.Errorf("ClientStats.NumCallsFinishedKnownReceived = %d, want %d", got.NumCallsFinishedKnownReceived, int64(countRPC)/2)
+		}
+		if got.NumCallsDropped[lbToken] != int64(countRPC)/2 {
+			t.Errorf("ClientStats.NumCallsDropped[%q] = %d, want %d", lbToken, got.NumCallsDropped[lbToken], int64(countRPC)/2)
+		}
+	}, &rpcStats{
+		numCallsStarted:               int64(countRPC),
+		numCallsFinished:              int64(countRPC),
+		numCallsFinishedKnownReceived: int64(countRPC) / 2,
+		numCallsDropped:               map[string]int64{lbToken: int64(countRPC) / 2},
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+ *
+ * Copyright 2020 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+// Package state declares grpclb types to be set by resolvers wanting to
+// pass information to grpclb via resolver.State Attributes without
+// introducing a dependency on the grpclb package.
+package state
+
+import (
+	"github.com/qiaohao9/grpc/resolver"
+)
+
+// keyType is the key to use for storing State in Attributes.
+type keyType string
+
+const key = keyType("grpc.grpclb.state")
+
+// State contains gRPCLB-relevant data passed from the name resolver.
+type State struct {
+	// BalancerAddresses contains the remote balancer addresses associated
+	// with the server list provided to grpclb. It is populated by name
+	// resolvers that are aware of grpclb, and is in addition to the
+	// addresses provided directly to grpclb via the resolver.State.
+	BalancerAddresses []resolver.Address
+}
+
+// Set returns a copy of the provided state with attributes containing s.
+// The grpclb balancer will read the state from the attributes.
+func Set(state resolver.State, s *State) resolver.State {
+	state.Attributes = state.Attributes.WithValue(key, s)
+	return state
+}
+
+// Get returns the grpclb State in the resolver.State, and a bool indicating
+// whether the attribute was present.
+func Get(state resolver.State) (*State, bool) {
+	s, ok := state.Attributes.Value(key).(*State)
+	return s, ok
+}
@@ -73,7 +73,12 @@ var (
 		http2.ErrCodeInadequateSecurity: codes.PermissionDenied,
 		http2.ErrCodeHTTP11Required:     codes.Internal,
 	}
-	// HTTPStatusConvTab is the HTTP status code to gRPC error code conversion table.
+	// HTTPStatusConvTab is the default HTTP status code to gRPC error code
+	// conversion table, consulted by DefaultHTTPStatusMapper. The map is
+	// shared by every transport in the process, so mutating it races with
+	// them. A custom HTTPStatusMapper can be given only to the gRPC-Web
+	// paths (StatusFromHTTPStatus, WebServerConfig); ConnectOptions and
+	// ServerConfig have no HTTPStatusMapper field.
 	HTTPStatusConvTab = map[int]codes.Code{
 		// 400 Bad Request - INTERNAL.
 		http.StatusBadRequest: codes.Internal,
@@ -95,6 +100,28 @@ var (
 	logger = grpclog.Component("transport")
 )
 
+// HTTPStatusMapper maps an HTTP status code to the gRPC status code a
+// transport should report when a request fails before any grpc-status is
+// available to read (typically because an intermediary - a proxy, a load
+// balancer - terminated it first). A mapper passed to StatusFromHTTPStatus
+// or set in a WebServerConfig is consulted in place of
+// DefaultHTTPStatusMapper, so gRPC-Web callers needing non-default mappings
+// (e.g. treating 408 as codes.DeadlineExceeded, or 451 as
+// codes.PermissionDenied behind a legal-block proxy) don't have to mutate
+// the shared HTTPStatusConvTab. The HTTP/2 client and server transports
+// take no HTTPStatusMapper.
+type HTTPStatusMapper func(httpStatus int) codes.Code
+
+// DefaultHTTPStatusMapper is the HTTPStatusMapper used wherever none was
+// supplied explicitly. It consults HTTPStatusConvTab, falling back to
+// codes.Unknown for any status code not listed there.
+func DefaultHTTPStatusMapper(httpStatus int) codes.Code {
+	if code, ok := HTTPStatusConvTab[httpStatus]; ok {
+		return code
+	}
+	return codes.Unknown
+}
+
 // isReservedHeader checks whether hdr belongs to HTTP2 headers
 // reserved by gRPC protocol. Any other headers are classified as the
 // user-specified metadata.
@@ -316,6 +343,12 @@ func decodeGrpcMessageUnchecked(msg string) string {
 	return buf.String()
 }
 
+// bufWriter batches small writes (e.g. frame headers) into buf, and queues
+// larger ones - the common case for a data frame's message payload - by
+// reference into queued instead of copying them in, so a payload above
+// batchSize is only ever copied once, by the kernel, when queued is handed
+// to conn via a single vectored net.Buffers.WriteTo instead of one
+// conn.Write per chunk.
 type bufWriter struct {
 	buf       []byte
 	offset    int
@@ -323,6 +356,13 @@ type bufWriter struct {
 	conn      net.Conn
 	err       error
 
+	// queued holds buf[:offset] (if non-empty) followed by any queued
+	// caller-owned slices, pending the next Flush. A caller-owned slice is
+	// only ever queued immediately before a Flush in the same call, so it's
+	// never read back after the caller could have reused its storage.
+	queued    net.Buffers
+	queuedLen int
+
 	onFlush func()
 }
 
@@ -341,30 +381,57 @@ func (w *bufWriter) Write(b []byte) (n int, err error) {
 	if w.batchSize == 0 { // Buffer has been disabled.
 		return w.conn.Write(b)
 	}
+	if len(b) >= w.batchSize {
+		// Queue it by reference rather than copying it into buf in
+		// batchSize-sized chunks, and flush immediately so nothing holds
+		// onto the caller's slice past this call.
+		w.enqueueBufLocked()
+		w.enqueueLocked(b)
+		return len(b), w.Flush()
+	}
 	for len(b) > 0 {
 		nn := copy(w.buf[w.offset:], b)
 		b = b[nn:]
 		w.offset += nn
 		n += nn
 		if w.offset >= w.batchSize {
-			err = w.Flush()
+			w.enqueueBufLocked()
+			if err = w.Flush(); err != nil {
+				return n, err
+			}
 		}
 	}
-	return n, err
+	return n, nil
+}
+
+// enqueueBufLocked moves any pending buf[:offset] into queued.
+func (w *bufWriter) enqueueBufLocked() {
+	if w.offset == 0 {
+		return
+	}
+	w.enqueueLocked(w.buf[:w.offset])
+	w.offset = 0
+}
+
+func (w *bufWriter) enqueueLocked(b []byte) {
+	w.queued = append(w.queued, b)
+	w.queuedLen += len(b)
 }
 
 func (w *bufWriter) Flush() error {
 	if w.err != nil {
 		return w.err
 	}
-	if w.offset == 0 {
+	w.enqueueBufLocked()
+	if w.queuedLen == 0 {
 		return nil
 	}
 	if w.onFlush != nil {
 		w.onFlush()
 	}
-	_, w.err = w.conn.Write(w.buf[:w.offset])
-	w.offset = 0
+	_, w.err = w.queued.WriteTo(w.conn)
+	w.queued = w.queued[:0]
+	w.queuedLen = 0
 	return w.err
 }
 
@@ -398,6 +465,42 @@ func newFramer(conn net.Conn, writeBufferSize, readBufferSize int, maxHeaderList
 // parseDialTarget returns the network and address to pass to dialer.
 func parseDialTarget(target string) (string, string) {
 	net := "tcp"
+
+	// unix-abstract:name addresses an abstract Unix domain socket (Linux
+	// only); net.Dial takes that as network "unix" with a leading '@' in the
+	// address, which it turns into the leading NUL byte of an abstract name.
+	if n := "unix-abstract"; strings.HasPrefix(target, n+":") {
+		return "unix", "@" + target[len(n)+1:]
+	}
+
+	// vsock:cid:port addresses a VM-to-host/host-to-VM AF_VSOCK socket.
+	// There's no net.Dial network for it, so the caller is expected to
+	// supply its own ContextDialer keyed off the "vsock" network name.
+	if strings.HasPrefix(target, "vsock:") {
+		return "vsock", strings.TrimPrefix(target, "vsock:")
+	}
+
+	// unix://path is handled without going through url.Parse: when path
+	// contains a colon (e.g. a socket literally named "foo:1"), url.Parse
+	// mistakes everything up to it for a host:port authority and either
+	// mis-splits the address or fails outright.
+	if n := "unix://"; strings.HasPrefix(target, n) {
+		rest := target[len(n):]
+		if strings.HasPrefix(rest, "/") {
+			// Triple-slash form (unix:///path): no authority, so rest is
+			// the path verbatim, colons and all.
+			return "unix", rest
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			// Authority form (unix://authority/path): like a real URL's
+			// host, the authority isn't part of the socket path.
+			return "unix", rest[i:]
+		}
+		// No slash at all: a bare relative path, possibly containing a
+		// colon, not an authority.
+		return "unix", rest
+	}
+
 	m1 := strings.Index(target, ":")
 	m2 := strings.Index(target, ":/")
 	// handle unix:addr which will fail with url.Parse
@@ -0,0 +1,49 @@
+/*
+ *
+ * Copyright 2014 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package transport
+
+import "testing"
+
+func TestParseDialTarget(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		target   string
+		wantNet  string
+		wantAddr string
+	}{
+		{name: "tcp host:port", target: "localhost:50051", wantNet: "tcp", wantAddr: "localhost:50051"},
+		{name: "unix: absolute path", target: "unix:/tmp/socket", wantNet: "unix", wantAddr: "/tmp/socket"},
+		{name: "unix: relative path", target: "unix:socket", wantNet: "unix", wantAddr: "socket"},
+		{name: "unix:// absolute path", target: "unix:///tmp/socket", wantNet: "unix", wantAddr: "/tmp/socket"},
+		{name: "unix:// path with a colon", target: "unix:///tmp/socket:1", wantNet: "unix", wantAddr: "/tmp/socket:1"},
+		{name: "unix:// relative path with a colon", target: "unix://socket:1", wantNet: "unix", wantAddr: "socket:1"},
+		{name: "unix:// authority form", target: "unix://authority/path", wantNet: "unix", wantAddr: "/path"},
+		{name: "unix-abstract", target: "unix-abstract:my-socket", wantNet: "unix", wantAddr: "@my-socket"},
+		{name: "unix-abstract with colon in name", target: "unix-abstract:my:socket", wantNet: "unix", wantAddr: "@my:socket"},
+		{name: "vsock", target: "vsock:2:50051", wantNet: "vsock", wantAddr: "2:50051"},
+		{name: "unrecognized scheme falls back to tcp", target: "dns:///example.com:443", wantNet: "tcp", wantAddr: "dns:///example.com:443"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			gotNet, gotAddr := parseDialTarget(tt.target)
+			if gotNet != tt.wantNet || gotAddr != tt.wantAddr {
+				t.Fatalf("parseDialTarget(%q) = (%q, %q), want (%q, %q)", tt.target, gotNet, gotAddr, tt.wantNet, tt.wantAddr)
+			}
+		})
+	}
+}
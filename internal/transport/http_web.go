@@ -0,0 +1,478 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/metadata"
+	"github.com/qiaohao9/grpc/status"
+)
+
+// grpcWebContentType and grpcWebTextContentType are the two content-types a
+// gRPC-Web client may send, per
+// https://github.com/grpc/grpc/blob/master/doc/PROTOCOL-WEB.md. The "-text"
+// variant base64-encodes the entire body (both directions) so it can pass
+// through intermediaries that don't tolerate arbitrary binary bodies.
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+)
+
+// webFrameHeaderLen is the length, in bytes, of the frame header that
+// precedes every gRPC-Web data and trailer frame: a one-byte flags field
+// followed by a 4-byte big-endian payload length, identical in shape to the
+// gRPC-over-HTTP2 length-prefixed message framing.
+const webFrameHeaderLen = 5
+
+// webTrailerFlag is set on the first (flags) byte of a gRPC-Web frame
+// header to mark it as a trailer frame rather than a message frame.
+const webTrailerFlag = 0x80
+
+// webContentTypeFor returns the gRPC-Web content-type to use, text-encoded
+// when base64Encoded is set.
+func webContentTypeFor(base64Encoded bool) string {
+	if base64Encoded {
+		return grpcWebTextContentType
+	}
+	return grpcWebContentType
+}
+
+// parseWebContentType reports whether ct names a gRPC-Web content-type, and
+// if so, whether it's the base64 "-text" variant.
+func parseWebContentType(ct string) (base64Encoded, ok bool) {
+	switch {
+	case strings.HasPrefix(ct, grpcWebTextContentType):
+		return true, true
+	case strings.HasPrefix(ct, grpcWebContentType):
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+// webFramer reads and writes the gRPC-Web message/trailer framing described
+// in PROTOCOL-WEB.md over an HTTP/1.1 (or HTTP/1.1-shaped) request/response
+// body, transparently base64-encoding frames when running in "-text" mode.
+type webFramer struct {
+	w             io.Writer
+	r             io.Reader
+	base64Encoded bool
+
+	// textReadBuf holds already-decoded bytes not yet consumed by
+	// readExactly. textRawLeftover holds base64 characters read but not
+	// yet decoded because they didn't complete a 4-character group, since
+	// the wire format may deliver the body in arbitrarily-sized chunks
+	// that don't respect base64 group boundaries.
+	textReadBuf     []byte
+	textRawLeftover []byte
+
+	// textWriteLeftover holds 0-2 not-yet-encoded raw bytes left over from
+	// the last writeFrame call in "-text" mode: per PROTOCOL-WEB.md the
+	// whole body is a single base64 stream, so only the very last frame
+	// (the trailer, which always ends the response) may pad; encoding
+	// every frame independently would plant '=' padding mid-stream and
+	// corrupt anything read after it.
+	textWriteLeftover []byte
+}
+
+func newWebFramer(w io.Writer, r io.Reader, base64Encoded bool) *webFramer {
+	return &webFramer{w: w, r: r, base64Encoded: base64Encoded}
+}
+
+// writeFrame writes a single length-prefixed frame, setting the trailer
+// flag bit when trailer is true. trailer also marks this as the last frame
+// of the response for "-text" mode purposes (see textWriteLeftover), which
+// holds given the trailer frame always ends a gRPC-Web response.
+func (f *webFramer) writeFrame(trailer bool, payload []byte) error {
+	hdr := make([]byte, webFrameHeaderLen)
+	if trailer {
+		hdr[0] = webTrailerFlag
+	}
+	byteOrderPutUint32(hdr[1:], uint32(len(payload)))
+	buf := append(hdr, payload...)
+	if !f.base64Encoded {
+		_, err := f.w.Write(buf)
+		return err
+	}
+	return f.writeText(buf, trailer)
+}
+
+// writeText base64-encodes buf as a continuation of the same stream started
+// by any earlier writeFrame call on f, carrying over the 0-2 trailing bytes
+// that don't complete a 3-byte group instead of padding them now. When
+// final is set, everything - including any carried-over bytes - is flushed
+// and padded, since no more data will follow.
+func (f *webFramer) writeText(buf []byte, final bool) error {
+	buf = append(f.textWriteLeftover, buf...)
+	whole := len(buf) - len(buf)%3
+	if final {
+		whole = len(buf)
+	}
+	if whole > 0 {
+		enc := make([]byte, base64.StdEncoding.EncodedLen(whole))
+		base64.StdEncoding.Encode(enc, buf[:whole])
+		if _, err := f.w.Write(enc); err != nil {
+			return err
+		}
+	}
+	f.textWriteLeftover = append([]byte(nil), buf[whole:]...)
+	return nil
+}
+
+// WriteData writes a message frame carrying an already length-prefixed gRPC
+// message (the 5-byte gRPC compressed-flag+length header plus payload, as
+// produced by the regular gRPC codec).
+func (f *webFramer) WriteData(msg []byte) error {
+	return f.writeFrame(false, msg)
+}
+
+// WriteTrailer writes md (which must include "grpc-status" and may include
+// "grpc-message" and "grpc-status-details-bin") as a trailer frame.
+func (f *webFramer) WriteTrailer(md metadata.MD) error {
+	return f.writeFrame(true, marshalWebTrailer(md))
+}
+
+// byteOrderPutUint32 writes v to b in big-endian order; b must have length
+// >= 4. Kept local to avoid importing encoding/binary for a single call
+// site.
+func byteOrderPutUint32(b []byte, v uint32) {
+	b[0] = byte(v >> 24)
+	b[1] = byte(v >> 16)
+	b[2] = byte(v >> 8)
+	b[3] = byte(v)
+}
+
+func byteOrderUint32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// ReadFrame reads the next frame, reporting whether it's a trailer frame.
+func (f *webFramer) ReadFrame() (trailer bool, payload []byte, err error) {
+	hdr, err := f.readExactly(webFrameHeaderLen)
+	if err != nil {
+		return false, nil, err
+	}
+	trailer = hdr[0]&webTrailerFlag != 0
+	n := byteOrderUint32(hdr[1:])
+	payload, err = f.readExactly(int(n))
+	if err != nil {
+		return false, nil, err
+	}
+	return trailer, payload, nil
+}
+
+// readExactly reads n raw (post-base64-decoding, if applicable) bytes,
+// buffering and decoding whatever extra base64 input arrives alongside it
+// in "-text" mode.
+func (f *webFramer) readExactly(n int) ([]byte, error) {
+	if !f.base64Encoded {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(f.r, buf); err != nil {
+			return nil, err
+		}
+		return buf, nil
+	}
+	for len(f.textReadBuf) < n {
+		raw := make([]byte, 4096)
+		nr, err := f.r.Read(raw)
+		if nr > 0 {
+			// Carry over any base64 characters left over from the last
+			// read that didn't complete a 4-character (3-byte) group, so a
+			// chunk boundary never splits a group.
+			buf := append(f.textRawLeftover, raw[:nr]...)
+			whole := len(buf) - len(buf)%4
+			if whole > 0 {
+				dec := make([]byte, base64.StdEncoding.DecodedLen(whole))
+				dn, derr := base64.StdEncoding.Decode(dec, buf[:whole])
+				if derr != nil {
+					return nil, derr
+				}
+				f.textReadBuf = append(f.textReadBuf, dec[:dn]...)
+			}
+			f.textRawLeftover = append([]byte(nil), buf[whole:]...)
+		}
+		if err != nil {
+			if err == io.EOF && len(f.textReadBuf) >= n {
+				break
+			}
+			return nil, err
+		}
+	}
+	buf := f.textReadBuf[:n]
+	f.textReadBuf = f.textReadBuf[n:]
+	return buf, nil
+}
+
+// marshalWebTrailer renders md as the HTTP/1.1-header-shaped trailer block
+// gRPC-Web carries in-body: one "key: value\r\n" line per entry, binary
+// ("-bin") values base64-encoded and "grpc-message" percent-encoded the same
+// way it is for real HTTP/2 trailers. Other values are written as they are.
+func marshalWebTrailer(md metadata.MD) []byte {
+	var buf bytes.Buffer
+	for k, vs := range md {
+		for _, v := range vs {
+			if strings.HasSuffix(k, binHdrSuffix) {
+				v = encodeBinHeader([]byte(v))
+			} else if k == "grpc-message" {
+				v = encodeGrpcMessage(v)
+			}
+			fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+		}
+	}
+	return buf.Bytes()
+}
+
+// unmarshalWebTrailer parses a trailer frame payload produced by
+// marshalWebTrailer back into metadata, decoding "-bin" values and
+// "grpc-message" the same way real HTTP/2 trailers are decoded.
+func unmarshalWebTrailer(b []byte) (metadata.MD, error) {
+	md := metadata.MD{}
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\r\n"), "\r\n") {
+		if line == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(line, ": ")
+		if !ok {
+			return nil, fmt.Errorf("transport: malformed grpc-web trailer line %q", line)
+		}
+		k = strings.ToLower(k)
+		if strings.HasSuffix(k, binHdrSuffix) {
+			decoded, err := decodeBinHeader(v)
+			if err != nil {
+				return nil, err
+			}
+			v = string(decoded)
+		} else if k == "grpc-message" {
+			v = decodeGrpcMessage(v)
+		}
+		md[k] = append(md[k], v)
+	}
+	return md, nil
+}
+
+// statusFromWebTrailer extracts the RPC status out of a parsed trailer,
+// mirroring how HTTP/2 trailers are turned into a status.Status.
+func statusFromWebTrailer(md metadata.MD) (*status.Status, error) {
+	statusVals := md.Get("grpc-status")
+	if len(statusVals) == 0 {
+		return nil, fmt.Errorf("transport: grpc-web trailer is missing grpc-status")
+	}
+	code, err := strconv.Atoi(statusVals[0])
+	if err != nil {
+		return nil, fmt.Errorf("transport: invalid grpc-status %q: %v", statusVals[0], err)
+	}
+	msg := ""
+	if m := md.Get("grpc-message"); len(m) > 0 {
+		msg = m[0]
+	}
+	return status.New(codes.Code(code), msg), nil
+}
+
+// StatusFromHTTPStatus maps an HTTP/1.1 response status code to a gRPC
+// status, for the case where an intermediary (proxy, load balancer)
+// terminates the request before a gRPC-Web trailer frame is ever produced.
+// mapper is consulted in place of DefaultHTTPStatusMapper when non-nil, so a
+// gRPC-Web client can use its own HTTPStatusMapper.
+func StatusFromHTTPStatus(httpStatus int, mapper HTTPStatusMapper) *status.Status {
+	if mapper == nil {
+		mapper = DefaultHTTPStatusMapper
+	}
+	return status.New(mapper(httpStatus), fmt.Sprintf("unexpected HTTP status code received from server: %d (%s)", httpStatus, http.StatusText(httpStatus)))
+}
+
+// WebStreamHandler is invoked once per incoming gRPC-Web request, with a
+// *WebServerStream ready to read the request message(s) and write back a
+// response plus trailer.
+type WebStreamHandler func(stream *WebServerStream)
+
+// WebServerStream adapts a single gRPC-Web HTTP/1.1 request/response pair
+// to look like a regular gRPC server stream: Read returns request message
+// frames, Write sends response message frames, and WriteStatus sends the
+// final in-body trailer frame that carries the RPC status.
+type WebServerStream struct {
+	ctx          context.Context
+	method       string
+	header       metadata.MD
+	framer       *webFramer
+	rw           http.ResponseWriter
+	statusMapper HTTPStatusMapper
+
+	sentHeader bool
+}
+
+// StatusMapper returns the HTTPStatusMapper this stream's server was
+// configured with (see WebServerConfig), for a handler that needs to turn an
+// HTTP status code of its own into a gRPC status consistently with the rest
+// of the server.
+func (s *WebServerStream) StatusMapper() HTTPStatusMapper { return s.statusMapper }
+
+// Context returns the stream's context, with a deadline derived from the
+// request's "grpc-timeout" header, if any.
+func (s *WebServerStream) Context() context.Context { return s.ctx }
+
+// Method returns the RPC method, taken from the request's URL path (e.g.
+// "/package.Service/Method").
+func (s *WebServerStream) Method() string { return s.method }
+
+// Header returns the request metadata decoded from the HTTP/1.1 headers.
+func (s *WebServerStream) Header() metadata.MD { return s.header }
+
+// Read returns the next gRPC message frame from the request body.
+func (s *WebServerStream) Read() ([]byte, error) {
+	trailer, payload, err := s.framer.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+	if trailer {
+		// gRPC-Web requests never carry a trailer frame; treat one as the
+		// end of the request body.
+		return nil, io.EOF
+	}
+	return payload, nil
+}
+
+func (s *WebServerStream) sendHeader() {
+	if s.sentHeader {
+		return
+	}
+	s.sentHeader = true
+	s.rw.Header().Set("Content-Type", webContentTypeFor(s.framer.base64Encoded))
+	s.rw.WriteHeader(http.StatusOK)
+}
+
+// Write sends msg (an already gRPC-framed message, as produced by the
+// regular codec) as a message frame, flushing it to the client immediately
+// so streaming responses aren't buffered until the handler returns.
+func (s *WebServerStream) Write(msg []byte) error {
+	s.sendHeader()
+	if err := s.framer.WriteData(msg); err != nil {
+		return err
+	}
+	if f, ok := s.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// WriteStatus sends the final trailer frame carrying st and any trailer
+// metadata, ending the response. It is the gRPC-Web equivalent of sending
+// HTTP/2 trailers.
+func (s *WebServerStream) WriteStatus(st *status.Status, trailer metadata.MD) error {
+	s.sendHeader()
+	md := metadata.Join(trailer, metadata.Pairs("grpc-status", strconv.Itoa(int(st.Code()))))
+	if m := st.Message(); m != "" {
+		// Not percent-encoded here: marshalWebTrailer does that for every
+		// "grpc-message" entry it writes, and encoding twice would corrupt
+		// any message containing a non-ASCII byte or a literal '%'.
+		md.Set("grpc-message", m)
+	}
+	if err := s.framer.WriteTrailer(md); err != nil {
+		return err
+	}
+	if f, ok := s.rw.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// WebServerConfig carries the optional, per-server knobs for ServeWebHTTP.
+// The zero value selects the same defaults ServeWebHTTP used before this
+// type existed.
+type WebServerConfig struct {
+	// StatusMapper, if non-nil, replaces DefaultHTTPStatusMapper for any
+	// gRPC-Web status derived from an HTTP status code on this server,
+	// without touching the shared HTTPStatusConvTab.
+	StatusMapper HTTPStatusMapper
+}
+
+func (c *WebServerConfig) statusMapper() HTTPStatusMapper {
+	if c != nil && c.StatusMapper != nil {
+		return c.StatusMapper
+	}
+	return DefaultHTTPStatusMapper
+}
+
+// ServeWebHTTP adapts an incoming gRPC-Web request into a WebServerStream
+// and invokes handle with it, so a gRPC-Web codec can be mounted inside a
+// plain net/http server alongside the HTTP/2 server that handles regular
+// gRPC traffic, typically behind a Content-Type switch in the outer
+// http.Handler:
+//
+//	switch {
+//	case strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc-web"):
+//	    transport.ServeWebHTTP(w, req, handle, cfg)
+//	default:
+//	    h2Server.ServeHTTP(w, req)
+//	}
+//
+// cfg may be nil to use the defaults. It returns an error without calling
+// handle if req isn't a recognized gRPC-Web request.
+func ServeWebHTTP(w http.ResponseWriter, req *http.Request, handle WebStreamHandler, cfg *WebServerConfig) error {
+	base64Encoded, ok := parseWebContentType(req.Header.Get("Content-Type"))
+	if !ok {
+		http.Error(w, "unsupported content-type", http.StatusUnsupportedMediaType)
+		return fmt.Errorf("transport: not a grpc-web request: %q", req.Header.Get("Content-Type"))
+	}
+
+	ctx := req.Context()
+	header := metadata.MD{}
+	for k, vs := range req.Header {
+		k = strings.ToLower(k)
+		if k == "grpc-timeout" {
+			if len(vs) > 0 {
+				if d, err := decodeTimeout(vs[0]); err == nil {
+					var cancel context.CancelFunc
+					ctx, cancel = context.WithTimeout(ctx, d)
+					defer cancel()
+				}
+			}
+			continue
+		}
+		if isReservedHeader(k) && !isWhitelistedHeader(k) {
+			continue
+		}
+		for _, v := range vs {
+			decoded, err := decodeMetadataHeader(k, v)
+			if err != nil {
+				continue
+			}
+			header[k] = append(header[k], decoded)
+		}
+	}
+
+	stream := &WebServerStream{
+		ctx:          ctx,
+		method:       req.URL.Path,
+		header:       header,
+		framer:       newWebFramer(w, req.Body, base64Encoded),
+		rw:           w,
+		statusMapper: cfg.statusMapper(),
+	}
+	handle(stream)
+	return nil
+}
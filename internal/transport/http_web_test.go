@@ -0,0 +1,161 @@
+/*
+ *
+ * Copyright 2023 gRPC authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package transport
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/qiaohao9/grpc/codes"
+	"github.com/qiaohao9/grpc/metadata"
+)
+
+// chunkReader dribbles out data in fixed-size chunks, regardless of the
+// size of the buffer it's asked to fill, to exercise readExactly's
+// handling of reads that don't land on a base64 4-character boundary.
+type chunkReader struct {
+	data  []byte
+	pos   int
+	chunk int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if c.pos >= len(c.data) {
+		return 0, io.EOF
+	}
+	n := c.chunk
+	if n > len(p) {
+		n = len(p)
+	}
+	if c.pos+n > len(c.data) {
+		n = len(c.data) - c.pos
+	}
+	copy(p, c.data[c.pos:c.pos+n])
+	c.pos += n
+	return n, nil
+}
+
+func TestWebFramerRoundTrip(t *testing.T) {
+	for _, base64Encoded := range []bool{false, true} {
+		t.Run(webContentTypeFor(base64Encoded), func(t *testing.T) {
+			var buf bytes.Buffer
+			wf := newWebFramer(&buf, nil, base64Encoded)
+			if err := wf.WriteData([]byte("hello")); err != nil {
+				t.Fatalf("WriteData: %v", err)
+			}
+			wantTrailer := metadata.Pairs("grpc-status", "0")
+			if err := wf.WriteTrailer(wantTrailer); err != nil {
+				t.Fatalf("WriteTrailer: %v", err)
+			}
+
+			rf := newWebFramer(nil, bytes.NewReader(buf.Bytes()), base64Encoded)
+			trailer, payload, err := rf.ReadFrame()
+			if err != nil {
+				t.Fatalf("ReadFrame (data): %v", err)
+			}
+			if trailer {
+				t.Fatal("ReadFrame (data): got trailer frame, want data frame")
+			}
+			if string(payload) != "hello" {
+				t.Fatalf("ReadFrame (data): payload = %q, want %q", payload, "hello")
+			}
+
+			trailer, payload, err = rf.ReadFrame()
+			if err != nil {
+				t.Fatalf("ReadFrame (trailer): %v", err)
+			}
+			if !trailer {
+				t.Fatal("ReadFrame (trailer): got data frame, want trailer frame")
+			}
+			md, err := unmarshalWebTrailer(payload)
+			if err != nil {
+				t.Fatalf("unmarshalWebTrailer: %v", err)
+			}
+			if got := md.Get("grpc-status"); len(got) != 1 || got[0] != "0" {
+				t.Fatalf("trailer grpc-status = %v, want [0]", got)
+			}
+
+			if _, _, err := rf.ReadFrame(); err != io.EOF {
+				t.Fatalf("ReadFrame (past end): err = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+// TestWebFramerTextModeChunkedRead verifies that base64 text-mode reads are
+// correctly reassembled even when the underlying reader delivers chunks
+// that split a 4-character base64 group across reads.
+func TestWebFramerTextModeChunkedRead(t *testing.T) {
+	var buf bytes.Buffer
+	wf := newWebFramer(&buf, nil, true)
+	payload := []byte("a message long enough to span several base64 groups")
+	if err := wf.WriteData(payload); err != nil {
+		t.Fatalf("WriteData: %v", err)
+	}
+	// A real response always ends with a trailer frame; only it flushes
+	// and pads the "-text" base64 stream (see writeText), so without it
+	// the data frame's tail would never reach the wire at all.
+	if err := wf.WriteTrailer(metadata.Pairs("grpc-status", "0")); err != nil {
+		t.Fatalf("WriteTrailer: %v", err)
+	}
+
+	for _, chunkSize := range []int{1, 2, 3, 5, 7} {
+		rf := newWebFramer(nil, &chunkReader{data: buf.Bytes(), chunk: chunkSize}, true)
+		_, got, err := rf.ReadFrame()
+		if err != nil {
+			t.Fatalf("chunk size %d: ReadFrame: %v", chunkSize, err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("chunk size %d: payload = %q, want %q", chunkSize, got, payload)
+		}
+	}
+}
+
+func TestMarshalUnmarshalWebTrailer(t *testing.T) {
+	md := metadata.Pairs(
+		"grpc-status", "13",
+		"grpc-message", "oops: 100% failure",
+		"x-custom-bin", string([]byte{0, 1, 2, 255}),
+	)
+	got, err := unmarshalWebTrailer(marshalWebTrailer(md))
+	if err != nil {
+		t.Fatalf("unmarshalWebTrailer: %v", err)
+	}
+	for k, want := range md {
+		if gotVals := got[k]; len(gotVals) != len(want) || gotVals[0] != want[0] {
+			t.Fatalf("trailer[%q] = %v, want %v", k, gotVals, want)
+		}
+	}
+}
+
+func TestStatusFromWebTrailer(t *testing.T) {
+	md := metadata.Pairs("grpc-status", "5", "grpc-message", "not found")
+	st, err := statusFromWebTrailer(md)
+	if err != nil {
+		t.Fatalf("statusFromWebTrailer: %v", err)
+	}
+	if st.Code() != codes.NotFound || st.Message() != "not found" {
+		t.Fatalf("statusFromWebTrailer = %v, want code %v message %q", st, codes.NotFound, "not found")
+	}
+
+	if _, err := statusFromWebTrailer(metadata.MD{}); err == nil {
+		t.Fatal("statusFromWebTrailer with no grpc-status: got nil error, want one")
+	}
+}